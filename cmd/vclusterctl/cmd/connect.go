@@ -4,12 +4,16 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/loft-sh/vcluster/pkg/postconnect"
 	"github.com/loft-sh/vcluster/pkg/upgrade"
 	"github.com/loft-sh/vcluster/pkg/util/kubeconfig"
 	"github.com/loft-sh/vcluster/pkg/util/podhelper"
@@ -42,7 +46,40 @@ type ConnectCmd struct {
 
 	Server string
 
+	ServiceDNS bool
+	InCluster  bool
+
+	Overwrite  bool
+	SetCurrent bool
+	Prune      bool
+
+	AllNamespaces bool
+	Selector      string
+
+	PostConnectManifests []string
+	RegistryCreds        []string
+	PostConnectNamespace string
+
 	Log log.Logger
+
+	// onConnected is called once the kube config for this connection has been
+	// written, with the server address the caller ended up using. It is set by
+	// ConnectMultiple to collect the summary table without having to wait for
+	// the (blocking) port forward to exit.
+	onConnected func(server string)
+
+	// stopCh, when set, stops this connection's port-forward once closed.
+	// ConnectMultiple sets it to a channel shared across every target so it
+	// can wait for every forwarder to actually exit before returning. A
+	// standalone connect creates its own, tied to SIGINT/SIGTERM, when this is
+	// left nil.
+	stopCh chan struct{}
+
+	// onForwarding is called right before this connection blocks on its local
+	// port forward. ConnectMultiple uses it to know whether there's anything
+	// to wait for at all - a target reached via --service-dns or a
+	// LoadBalancer never calls it, since it returns without forwarding.
+	onForwarding func()
 }
 
 // NewConnectCmd creates a new command
@@ -63,9 +100,13 @@ Connect to a virtual cluster
 
 Example:
 vcluster connect test --namespace test
+
+# connect to several vclusters at once, sharing one merged kube config
+vcluster connect test-a test-b --namespace test
+vcluster connect --all-namespaces --selector app=vcluster
 #######################################################
 	`,
-		Args: cobra.MaximumNArgs(1),
+		Args: cobra.ArbitraryArgs,
 		RunE: func(cobraCmd *cobra.Command, args []string) error {
 			// Check for newer version
 			upgrade.PrintNewerVersionWarning()
@@ -81,11 +122,25 @@ vcluster connect test --namespace test
 	cobraCmd.Flags().StringVar(&cmd.Server, "server", "", "The server to connect to")
 	cobraCmd.Flags().IntVar(&cmd.LocalPort, "local-port", 8443, "The local port to forward the virtual cluster to")
 	cobraCmd.Flags().StringVar(&cmd.Address, "address", "", "The local address to start port forwarding under")
+	cobraCmd.Flags().BoolVar(&cmd.ServiceDNS, "service-dns", false, "If true rewrites the kube config server to the in-cluster service DNS name instead of port-forwarding. Useful for debugging connect from outside the host cluster")
+	cobraCmd.Flags().BoolVar(&cmd.InCluster, "in-cluster", false, "If true loads the host cluster credentials via rest.InClusterConfig() instead of the default kube config loading rules. Automatically enabled when running inside a pod")
+	cobraCmd.Flags().BoolVar(&cmd.Overwrite, "overwrite", false, "If true allows --update-current to replace an existing cluster/user/context entry that wasn't created by vcluster")
+	cobraCmd.Flags().BoolVar(&cmd.SetCurrent, "set-current", false, "If true makes the vcluster context the current context. Only takes effect together with --update-current")
+	cobraCmd.Flags().BoolVar(&cmd.Prune, "prune", false, "If true removes stale vcluster-managed contexts from the current kube config before connecting")
+	cobraCmd.Flags().BoolVar(&cmd.AllNamespaces, "all-namespaces", false, "If true connects to every matching vcluster across all namespaces instead of just the current one")
+	cobraCmd.Flags().StringVar(&cmd.Selector, "selector", "", "Connect to every vcluster matching this label selector (defaults to app=vcluster). Implies connecting to multiple vclusters at once")
+	cobraCmd.Flags().StringArrayVar(&cmd.PostConnectManifests, "post-connect-manifest", nil, "A local file path or http(s) URL of a manifest to apply to the vcluster once it is reachable. Can be specified multiple times")
+	cobraCmd.Flags().StringArrayVar(&cmd.RegistryCreds, "registry-creds", nil, "A provider=file pair, e.g. ecr=creds.json, seeded as a registry-creds-<provider> image-pull secret once the vcluster is reachable. Can be specified multiple times")
+	cobraCmd.Flags().StringVar(&cmd.PostConnectNamespace, "post-connect-namespace", postconnect.DefaultNamespace, "The namespace --post-connect-manifest and --registry-creds operate in")
 	return cobraCmd
 }
 
 // Run executes the functionality
 func (cmd *ConnectCmd) Run(args []string) error {
+	if cmd.AllNamespaces || cmd.Selector != "" || len(args) > 1 {
+		return cmd.ConnectMultiple(args)
+	}
+
 	vclusterName := ""
 	if len(args) > 0 {
 		vclusterName = args[0]
@@ -94,32 +149,68 @@ func (cmd *ConnectCmd) Run(args []string) error {
 	return cmd.Connect(vclusterName)
 }
 
-func (cmd *ConnectCmd) Connect(vclusterName string) error {
-	kubeConfigLoader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{
-		CurrentContext: cmd.Context,
-	})
-	restConfig, err := kubeConfigLoader.ClientConfig()
-	if err != nil {
-		return errors.Wrap(err, "load kube config")
+// resolveClient loads the rest config and kube client to talk to the host
+// cluster, honoring --in-cluster and auto-detection, and fills in cmd.Namespace
+// if it wasn't set explicitly.
+func (cmd *ConnectCmd) resolveClient() (*rest.Config, *kubernetes.Clientset, error) {
+	inCluster := cmd.InCluster || isRunningInCluster()
+
+	var restConfig *rest.Config
+	var err error
+	var kubeConfigLoader clientcmd.ClientConfig
+	if inCluster {
+		restConfig, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "load in-cluster config")
+		}
+	} else {
+		kubeConfigLoader = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{
+			CurrentContext: cmd.Context,
+		})
+		restConfig, err = kubeConfigLoader.ClientConfig()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "load kube config")
+		}
 	}
+
 	kubeClient, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		return errors.Wrap(err, "create kube client")
+		return nil, nil, errors.Wrap(err, "create kube client")
 	}
 
 	// set the namespace correctly
 	if cmd.Namespace == "" {
-		cmd.Namespace, _, err = kubeConfigLoader.Namespace()
-		if err != nil {
-			return err
+		if inCluster {
+			cmd.Namespace, err = currentNamespace()
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "get current namespace")
+			}
+		} else {
+			cmd.Namespace, _, err = kubeConfigLoader.Namespace()
+			if err != nil {
+				return nil, nil, err
+			}
 		}
 	}
 
+	return restConfig, kubeClient, nil
+}
+
+func (cmd *ConnectCmd) Connect(vclusterName string) error {
+	inCluster := cmd.InCluster || isRunningInCluster()
+
+	restConfig, kubeClient, err := cmd.resolveClient()
+	if err != nil {
+		return err
+	}
+
 	if vclusterName == "" && cmd.PodName == "" {
 		return fmt.Errorf("please specify either --pod or a name for the vcluster")
 	}
 
 	podName := cmd.PodName
+	var kubeConfig *api.Config
+
 	if podName == "" {
 		err = wait.PollImmediate(time.Second, time.Second*10, func() (done bool, err error) {
 			// get vcluster pod name
@@ -149,7 +240,7 @@ func (cmd *ConnectCmd) Connect(vclusterName string) error {
 	}
 
 	// get the kube config from the the Secret
-	kubeConfig, err := GetKubeConfig(context.Background(), kubeClient, vclusterName, restConfig, podName, cmd.Namespace, cmd.Log)
+	kubeConfig, err = GetKubeConfig(context.Background(), kubeClient, vclusterName, restConfig, podName, cmd.Namespace, cmd.Log)
 	if err != nil {
 		return errors.Wrap(err, "failed to parse kube config")
 	}
@@ -159,6 +250,35 @@ func (cmd *ConnectCmd) Connect(vclusterName string) error {
 		return fmt.Errorf("unexpected kube config")
 	}
 
+	// if we are running inside the host cluster (or were asked to via --service-dns),
+	// skip port-forwarding entirely and point the kube config at the in-cluster service
+	// DNS name instead.
+	if cmd.ServiceDNS || inCluster {
+		if vclusterName == "" {
+			return fmt.Errorf("please specify a vcluster name to use --service-dns or --in-cluster")
+		}
+
+		server := fmt.Sprintf("https://%s.%s.svc:443", vclusterName, cmd.Namespace)
+		for k := range kubeConfig.Clusters {
+			kubeConfig.Clusters[k].Server = server
+		}
+
+		err = cmd.writeKubeConfig(kubeConfig, vclusterName)
+		if err != nil {
+			return err
+		}
+
+		if err := cmd.runPostConnectHooks(kubeConfig, server); err != nil {
+			return err
+		}
+
+		cmd.Log.Infof("Using vcluster %s in-cluster service endpoint: %s", vclusterName, server)
+		if cmd.onConnected != nil {
+			cmd.onConnected(server)
+		}
+		return nil
+	}
+
 	// check if the vcluster is exposed
 	if vclusterName != "" && cmd.Server == "" {
 		printedWaiting := false
@@ -224,13 +344,88 @@ func (cmd *ConnectCmd) Connect(vclusterName string) error {
 		}
 	}
 
+	err = cmd.writeKubeConfig(kubeConfig, vclusterName)
+	if err != nil {
+		return err
+	}
+
+	if cmd.onConnected != nil {
+		server := cmd.Server
+		if server == "" {
+			server = fmt.Sprintf("https://localhost:%d", cmd.LocalPort)
+		}
+		cmd.onConnected(server)
+	}
+
+	if cmd.Server != "" {
+		// already reachable directly, e.g. a LoadBalancer endpoint - no need to
+		// wait for a port-forward to come up.
+		return cmd.runPostConnectHooks(kubeConfig, cmd.Server)
+	}
+
+	if postConnectOpts, err := cmd.postConnectOptions(); err != nil {
+		return err
+	} else if postConnectOpts.HasWork() {
+		// the local port only starts accepting connections once the port-forward
+		// below is running, so seed the vcluster in the background once it does
+		// instead of blocking the port-forward on it.
+		go func() {
+			err := waitForLocalPort(cmd.LocalPort, time.Minute)
+			if err != nil {
+				cmd.Log.Errorf("post-connect: %v", err)
+				return
+			}
+
+			restConfig, err := restConfigForKubeConfig(kubeConfig, fmt.Sprintf("https://localhost:%d", cmd.LocalPort))
+			if err != nil {
+				cmd.Log.Errorf("post-connect: %v", err)
+				return
+			}
+
+			err = postconnect.Run(context.Background(), restConfig, postConnectOpts)
+			if err != nil {
+				cmd.Log.Errorf("post-connect: %v", err)
+				return
+			}
+
+			cmd.Log.Donef("Seeded post-connect manifests and registry credentials")
+		}()
+	}
+
+	stopCh := cmd.stopCh
+	if stopCh == nil {
+		stopCh = make(chan struct{})
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigCh
+			close(stopCh)
+		}()
+	}
+
+	if cmd.onForwarding != nil {
+		cmd.onForwarding()
+	}
+
+	return portforward.StartPortForwardingWithRestart(restConfig, cmd.Address, podName, cmd.Namespace, strconv.Itoa(cmd.LocalPort), port, stopCh, cmd.Log)
+}
+
+// writeKubeConfig persists the generated kube config the same way regardless of
+// whether the server was rewritten for port-forwarding or for in-cluster service DNS.
+func (cmd *ConnectCmd) writeKubeConfig(kubeConfig *api.Config, vclusterName string) error {
 	out, err := clientcmd.Write(*kubeConfig)
 	if err != nil {
 		return err
 	}
 
-	// write kube config to file
 	if cmd.UpdateCurrent {
+		if cmd.Prune {
+			err = pruneCurrentKubeConfig(vclusterName)
+			if err != nil {
+				return err
+			}
+		}
+
 		var clusterConfig *api.Cluster
 		for _, c := range kubeConfig.Clusters {
 			clusterConfig = c
@@ -247,54 +442,168 @@ func (cmd *ConnectCmd) Connect(vclusterName string) error {
 		} else {
 			contextName = "vcluster_" + cmd.Namespace + "_" + cmd.PodName
 		}
-		err = updateKubeConfig(contextName, clusterConfig, authConfig, false)
+		err = kubeconfig.WriteMergedKubeConfig("", clusterConfig, authConfig, kubeconfig.MergeOptions{
+			ContextName:  contextName,
+			VClusterName: vclusterName,
+			Namespace:    cmd.Namespace,
+			Overwrite:    cmd.Overwrite,
+			SetCurrent:   cmd.SetCurrent,
+		})
 		if err != nil {
 			return err
 		}
 
 		cmd.Log.Donef("Successfully created kube context %s. You can access the vcluster with `kubectl get namespaces --context %s`", contextName, contextName)
+		return nil
 	} else if cmd.Print {
 		_, err = os.Stdout.Write(out)
-		if err != nil {
-			return err
-		}
-	} else {
-		err = ioutil.WriteFile(cmd.KubeConfig, out, 0666)
-		if err != nil {
-			return errors.Wrap(err, "write kube config")
-		}
+		return err
+	}
 
-		cmd.Log.Donef("Virtual cluster kube config written to: %s. You can access the cluster via `kubectl --kubeconfig %s get namespaces`", cmd.KubeConfig, cmd.KubeConfig)
+	err = ioutil.WriteFile(cmd.KubeConfig, out, 0666)
+	if err != nil {
+		return errors.Wrap(err, "write kube config")
 	}
 
-	if cmd.Server != "" {
-		return nil
+	cmd.Log.Donef("Virtual cluster kube config written to: %s. You can access the cluster via `kubectl --kubeconfig %s get namespaces`", cmd.KubeConfig, cmd.KubeConfig)
+	return nil
+}
+
+// postConnectOptions builds a postconnect.Options from the --post-connect-manifest
+// and --registry-creds flags.
+func (cmd *ConnectCmd) postConnectOptions() (postconnect.Options, error) {
+	options := postconnect.Options{
+		ManifestPaths: cmd.PostConnectManifests,
+		Namespace:     cmd.PostConnectNamespace,
 	}
 
-	return portforward.StartPortForwardingWithRestart(restConfig, cmd.Address, podName, cmd.Namespace, strconv.Itoa(cmd.LocalPort), port, cmd.Log)
+	if len(cmd.RegistryCreds) > 0 {
+		options.RegistryCreds = map[string]string{}
+		for _, pair := range cmd.RegistryCreds {
+			provider, file, ok := strings.Cut(pair, "=")
+			if !ok {
+				return options, fmt.Errorf("invalid --registry-creds %q, expected provider=file", pair)
+			}
+
+			options.RegistryCreds[provider] = file
+		}
+	}
+
+	return options, nil
 }
 
-func updateKubeConfig(contextName string, cluster *api.Cluster, authInfo *api.AuthInfo, setActive bool) error {
-	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).RawConfig()
+// runPostConnectHooks seeds the vcluster at server (already reachable, no
+// port-forward involved) with the configured manifests and registry
+// credentials, if any were requested.
+func (cmd *ConnectCmd) runPostConnectHooks(kubeConfig *api.Config, server string) error {
+	options, err := cmd.postConnectOptions()
 	if err != nil {
 		return err
+	} else if !options.HasWork() {
+		return nil
 	}
 
-	config.Clusters[contextName] = cluster
-	config.AuthInfos[contextName] = authInfo
+	restConfig, err := restConfigForKubeConfig(kubeConfig, server)
+	if err != nil {
+		return err
+	}
 
-	// Update kube context
-	context := api.NewContext()
-	context.Cluster = contextName
-	context.AuthInfo = contextName
+	err = postconnect.Run(context.Background(), restConfig, options)
+	if err != nil {
+		return errors.Wrap(err, "run post-connect hooks")
+	}
+
+	cmd.Log.Donef("Seeded post-connect manifests and registry credentials")
+	return nil
+}
+
+// restConfigForKubeConfig builds a rest.Config for server out of the single
+// cluster/authInfo pair a generated vcluster kube config carries.
+func restConfigForKubeConfig(kubeConfig *api.Config, server string) (*rest.Config, error) {
+	var cluster *api.Cluster
+	for _, c := range kubeConfig.Clusters {
+		cluster = c
+	}
+
+	var authInfo *api.AuthInfo
+	for _, a := range kubeConfig.AuthInfos {
+		authInfo = a
+	}
+
+	if cluster == nil || authInfo == nil {
+		return nil, fmt.Errorf("unexpected kube config")
+	}
+
+	if !strings.HasPrefix(server, "https://") {
+		server = "https://" + server
+	}
 
-	config.Contexts[contextName] = context
-	if setActive {
-		config.CurrentContext = contextName
+	return &rest.Config{
+		Host: server,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData:   cluster.CertificateAuthorityData,
+			CertData: authInfo.ClientCertificateData,
+			KeyData:  authInfo.ClientKeyData,
+		},
+	}, nil
+}
+
+// waitForLocalPort blocks until something is listening on 127.0.0.1:port, or
+// timeout elapses.
+func waitForLocalPort(port int, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+
+		time.Sleep(time.Second)
+	}
+
+	return fmt.Errorf("timed out waiting for local port %d to come up", port)
+}
+
+// pruneCurrentKubeConfig removes every vcluster-managed context for vclusterName
+// (or all of them when empty) from the current kube config before a fresh
+// context is merged in.
+func pruneCurrentKubeConfig(vclusterName string) error {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).RawConfig()
+	if err != nil {
+		return errors.Wrap(err, "load kube config")
+	}
+
+	removed := kubeconfig.Prune(&config, vclusterName)
+	if len(removed) == 0 {
+		return nil
+	}
+
+	return clientcmd.ModifyConfig(rules, config, false)
+}
+
+// isRunningInCluster returns true if the current process is running inside a pod
+// of the host cluster, detected via the standard KUBERNETES_SERVICE_HOST env var
+// and a successful rest.InClusterConfig() load.
+func isRunningInCluster() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return false
+	}
+
+	_, err := rest.InClusterConfig()
+	return err == nil
+}
+
+// currentNamespace reads the namespace the pod's service account is bound to, as
+// mounted by Kubernetes at the well-known service account path.
+func currentNamespace() (string, error) {
+	namespace, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return "", errors.Wrap(err, "detect in-cluster namespace")
 	}
 
-	// Save the config
-	return clientcmd.ModifyConfig(clientcmd.NewDefaultClientConfigLoadingRules(), config, false)
+	return strings.TrimSpace(string(namespace)), nil
 }
 
 // GetKubeConfig attempts to read the kubeconfig from the default Secret and
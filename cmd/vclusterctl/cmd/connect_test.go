@@ -0,0 +1,15 @@
+package cmd
+
+import "testing"
+
+func TestIsRunningInCluster(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if isRunningInCluster() {
+		t.Error("expected isRunningInCluster to be false without KUBERNETES_SERVICE_HOST set")
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	if isRunningInCluster() {
+		t.Error("expected isRunningInCluster to be false outside a pod even with the env var set, since rest.InClusterConfig() still needs the token/CA files")
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"github.com/loft-sh/vcluster/cmd/vclusterctl/flags"
+	"github.com/loft-sh/vcluster/cmd/vclusterctl/log"
+	"github.com/loft-sh/vcluster/pkg/util/kubeconfig"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// DisconnectCmd holds the disconnect cmd flags
+type DisconnectCmd struct {
+	*flags.GlobalFlags
+
+	Log log.Logger
+}
+
+// NewDisconnectCmd creates a new command
+func NewDisconnectCmd(globalFlags *flags.GlobalFlags) *cobra.Command {
+	cmd := &DisconnectCmd{
+		GlobalFlags: globalFlags,
+		Log:         log.GetInstance(),
+	}
+
+	cobraCmd := &cobra.Command{
+		Use:   "disconnect",
+		Short: "Removes the kube context of a virtual cluster",
+		Long: `
+#######################################################
+################# vcluster disconnect ##################
+#######################################################
+Removes the kube context(s) created by a previous
+'vcluster connect --update-current'. Only contexts that
+vcluster created itself are removed, recognized by a marker
+vcluster stores on them (which stays on a renamed context);
+a context created manually is left untouched.
+
+Example:
+vcluster disconnect test
+#######################################################
+	`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			return cmd.Run(args)
+		},
+	}
+
+	return cobraCmd
+}
+
+// Run executes the functionality
+func (cmd *DisconnectCmd) Run(args []string) error {
+	vclusterName := ""
+	if len(args) > 0 {
+		vclusterName = args[0]
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).RawConfig()
+	if err != nil {
+		return errors.Wrap(err, "load kube config")
+	}
+
+	removed := kubeconfig.Prune(&config, vclusterName)
+	if len(removed) == 0 {
+		cmd.Log.Info("No vcluster-managed context found to remove")
+		return nil
+	}
+
+	err = clientcmd.ModifyConfig(rules, config, false)
+	if err != nil {
+		return errors.Wrap(err, "save kube config")
+	}
+
+	for _, contextName := range removed {
+		cmd.Log.Donef("Removed kube context %s", contextName)
+	}
+
+	return nil
+}
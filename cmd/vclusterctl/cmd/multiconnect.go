@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/loft-sh/vcluster/pkg/util/kubeconfig"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// vclusterRef identifies a single vcluster to connect to as part of a batch.
+type vclusterRef struct {
+	Name      string
+	Namespace string
+}
+
+// connectedRow is one line of the summary table printed once every vcluster
+// in the batch has written its part of the merged kube config.
+type connectedRow struct {
+	Name      string
+	Namespace string
+	Context   string
+	Server    string
+}
+
+// ConnectMultiple connects to several vclusters concurrently - either the
+// names given on the command line, or every vcluster matched by
+// --all-namespaces / --selector - assigning each a distinct local port. The
+// resulting contexts are merged either into a standalone kube config
+// (--kube-config, the default) or into the current kube config when
+// --update-current is set, same as a single `vcluster connect` would. All
+// port forwarders share this process; SIGINT/SIGTERM stops every one of them
+// and ConnectMultiple waits for them to actually exit before returning. If no
+// target actually started a port forward (e.g. every one used --service-dns
+// or a LoadBalancer endpoint), ConnectMultiple returns immediately instead of
+// waiting for a signal that would never matter.
+func (cmd *ConnectCmd) ConnectMultiple(args []string) error {
+	_, kubeClient, err := cmd.resolveClient()
+	if err != nil {
+		return err
+	}
+
+	refs, err := cmd.discoverTargets(kubeClient, args)
+	if err != nil {
+		return err
+	}
+
+	ports, err := assignLocalPorts(cmd.LocalPort, len(refs))
+	if err != nil {
+		return err
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	mergedKubeConfig := ""
+	if !cmd.UpdateCurrent {
+		mergedKubeConfig = cmd.KubeConfig
+		if mergedKubeConfig == "" || mergedKubeConfig == "./kubeconfig.yaml" {
+			mergedKubeConfig = "./merged-kubeconfig.yaml"
+		}
+
+		// make sure the merged kube config starts out empty so stale contexts from
+		// a previous run of this command don't linger.
+		err = clientcmd.WriteToFile(*api.NewConfig(), mergedKubeConfig)
+		if err != nil {
+			return errors.Wrap(err, "initialize merged kube config")
+		}
+	}
+
+	var (
+		mergeMu        sync.Mutex // serializes the read-modify-write of the shared/current kube config across targets
+		rowMu          sync.Mutex
+		rows           []connectedRow
+		readyWg        sync.WaitGroup
+		fwWg           sync.WaitGroup
+		forwarderCount int32 // number of targets that actually started a port forward, not just --service-dns/LoadBalancer ones
+	)
+	readyWg.Add(len(refs))
+	fwWg.Add(len(refs))
+
+	stopCh := make(chan struct{})
+
+	for i, ref := range refs {
+		i, ref := i, ref
+
+		sub := *cmd
+		sub.Namespace = ref.Namespace
+		sub.LocalPort = ports[i]
+		sub.PodName = ""
+		sub.Server = ""
+		sub.UpdateCurrent = false
+		sub.Print = false
+		sub.KubeConfig = filepath.Join(os.TempDir(), fmt.Sprintf("vcluster-connect-%s-%s.yaml", ref.Namespace, ref.Name))
+		sub.stopCh = stopCh
+		sub.onForwarding = func() {
+			atomic.AddInt32(&forwarderCount, 1)
+		}
+		contextName := "vcluster_" + ref.Namespace + "_" + ref.Name
+
+		var once sync.Once
+		sub.onConnected = func(server string) {
+			once.Do(func() {
+				defer readyWg.Done()
+
+				mergeMu.Lock()
+				err := mergeIntoSharedKubeConfig(mergedKubeConfig, rules, sub.KubeConfig, contextName, ref, cmd.Overwrite)
+				mergeMu.Unlock()
+				_ = os.Remove(sub.KubeConfig)
+				if err != nil {
+					cmd.Log.Errorf("merge kube config for %s/%s: %v", ref.Namespace, ref.Name, err)
+					return
+				}
+
+				rowMu.Lock()
+				rows = append(rows, connectedRow{Name: ref.Name, Namespace: ref.Namespace, Context: contextName, Server: server})
+				rowMu.Unlock()
+			})
+		}
+
+		go func() {
+			defer fwWg.Done()
+
+			err := sub.Connect(ref.Name)
+			if err != nil {
+				cmd.Log.Errorf("connect to %s/%s: %v", ref.Namespace, ref.Name, err)
+				once.Do(readyWg.Done)
+			}
+		}()
+	}
+
+	readyWg.Wait()
+	if mergedKubeConfig != "" {
+		cmd.printConnectedSummary(rows, mergedKubeConfig)
+	} else {
+		cmd.printConnectedSummary(rows, "your current kube config")
+	}
+
+	if atomic.LoadInt32(&forwarderCount) == 0 {
+		// every target was reached via --service-dns or a LoadBalancer
+		// endpoint - nothing is port-forwarding, so there's nothing to wait
+		// for or shut down.
+		return nil
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	<-sigCh
+
+	cmd.Log.Info("Shutting down all port forwarders...")
+	close(stopCh)
+	fwWg.Wait()
+	cmd.Log.Info("All port forwarders stopped")
+	return nil
+}
+
+// discoverTargets resolves the vclusters to connect to: the explicit names
+// passed on the command line, or a selector-based lookup across the current
+// namespace (or every namespace with --all-namespaces).
+func (cmd *ConnectCmd) discoverTargets(kubeClient *kubernetes.Clientset, args []string) ([]vclusterRef, error) {
+	if len(args) > 0 {
+		refs := make([]vclusterRef, 0, len(args))
+		for _, name := range args {
+			refs = append(refs, vclusterRef{Name: name, Namespace: cmd.Namespace})
+		}
+		return refs, nil
+	}
+
+	selector := cmd.Selector
+	if selector == "" {
+		selector = "app=vcluster"
+	}
+
+	namespace := cmd.Namespace
+	if cmd.AllNamespaces {
+		namespace = metav1.NamespaceAll
+	}
+
+	pods, err := kubeClient.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, errors.Wrap(err, "list vclusters")
+	}
+
+	seen := map[string]bool{}
+	var refs []vclusterRef
+	for _, pod := range pods.Items {
+		name := pod.Labels["release"]
+		if name == "" {
+			continue
+		}
+
+		key := pod.Namespace + "/" + name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		refs = append(refs, vclusterRef{Name: name, Namespace: pod.Namespace})
+	}
+
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("no vclusters found matching selector %q", selector)
+	}
+
+	return refs, nil
+}
+
+func (cmd *ConnectCmd) printConnectedSummary(rows []connectedRow, mergedKubeConfig string) {
+	if len(rows) == 0 {
+		cmd.Log.Info("No vcluster connected successfully")
+		return
+	}
+
+	cmd.Log.Donef("Connected %d vcluster(s), merged kube config written to: %s", len(rows), mergedKubeConfig)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAME\tNAMESPACE\tCONTEXT\tSERVER")
+	for _, row := range rows {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", row.Name, row.Namespace, row.Context, row.Server)
+	}
+	_ = writer.Flush()
+}
+
+// mergeIntoSharedKubeConfig merges the cluster/authInfo a single sub-connect
+// wrote to kubeConfigPath under contextName into the shared destination -
+// mergedKubeConfigPath if set, or the current kube config (loaded and saved
+// via rules) when it's empty, i.e. --update-current. overwrite is forwarded
+// to kubeconfig.Merge so a pre-existing, non-vcluster-owned entry at
+// contextName is protected the same way a single `vcluster connect` protects
+// it, unless --overwrite was passed. Callers must serialize calls to this
+// function themselves; it does one read-modify-write of the shared
+// destination per call and has no locking of its own.
+func mergeIntoSharedKubeConfig(mergedKubeConfigPath string, rules *clientcmd.ClientConfigLoadingRules, kubeConfigPath, contextName string, ref vclusterRef, overwrite bool) error {
+	generated, err := clientcmd.LoadFromFile(kubeConfigPath)
+	if err != nil {
+		return errors.Wrap(err, "load generated kube config")
+	}
+
+	var clusterConfig *api.Cluster
+	var authConfig *api.AuthInfo
+	for _, c := range generated.Clusters {
+		clusterConfig = c
+	}
+	for _, a := range generated.AuthInfos {
+		authConfig = a
+	}
+
+	var merged api.Config
+	if mergedKubeConfigPath != "" {
+		loaded, err := clientcmd.LoadFromFile(mergedKubeConfigPath)
+		if err != nil {
+			return errors.Wrap(err, "load merged kube config")
+		}
+		merged = *loaded
+	} else {
+		merged, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).RawConfig()
+		if err != nil {
+			return errors.Wrap(err, "load current kube config")
+		}
+	}
+
+	err = kubeconfig.Merge(&merged, clusterConfig, authConfig, kubeconfig.MergeOptions{
+		ContextName:  contextName,
+		VClusterName: ref.Name,
+		Namespace:    ref.Namespace,
+		Overwrite:    overwrite,
+	})
+	if err != nil {
+		return err
+	}
+
+	if mergedKubeConfigPath != "" {
+		return clientcmd.WriteToFile(merged, mergedKubeConfigPath)
+	}
+
+	return clientcmd.ModifyConfig(rules, merged, false)
+}
+
+// assignLocalPorts picks n distinct, currently free local ports starting at
+// basePort, auto-incrementing past any that are already taken.
+func assignLocalPorts(basePort, n int) ([]int, error) {
+	ports := make([]int, 0, n)
+	candidate := basePort
+	for len(ports) < n && candidate < basePort+1000 {
+		if isPortFree(candidate) {
+			ports = append(ports, candidate)
+		}
+		candidate++
+	}
+
+	if len(ports) < n {
+		return nil, fmt.Errorf("could not find %d free local ports starting at %d", n, basePort)
+	}
+
+	return ports, nil
+}
+
+func isPortFree(port int) bool {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return false
+	}
+
+	_ = listener.Close()
+	return true
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestAssignLocalPorts(t *testing.T) {
+	ports, err := assignLocalPorts(20000, 3)
+	if err != nil {
+		t.Fatalf("assignLocalPorts: %v", err)
+	}
+	if len(ports) != 3 {
+		t.Fatalf("expected 3 ports, got %d", len(ports))
+	}
+
+	seen := map[int]bool{}
+	for _, port := range ports {
+		if seen[port] {
+			t.Fatalf("port %d assigned twice: %v", port, ports)
+		}
+		seen[port] = true
+	}
+}
+
+func TestAssignLocalPortsSkipsTaken(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:20100")
+	if err != nil {
+		t.Skipf("could not bind test listener: %v", err)
+	}
+	defer listener.Close()
+
+	ports, err := assignLocalPorts(20100, 1)
+	if err != nil {
+		t.Fatalf("assignLocalPorts: %v", err)
+	}
+	if ports[0] == 20100 {
+		t.Errorf("expected the already-bound port 20100 to be skipped, got %v", ports)
+	}
+}
+
+func TestMergeIntoSharedKubeConfigFile(t *testing.T) {
+	dir := t.TempDir()
+
+	generatedPath := filepath.Join(dir, "generated.yaml")
+	generated := api.NewConfig()
+	generated.Clusters["x"] = &api.Cluster{Server: "https://vcluster.example.com"}
+	generated.AuthInfos["x"] = &api.AuthInfo{Token: "test-token"}
+	if err := clientcmd.WriteToFile(*generated, generatedPath); err != nil {
+		t.Fatalf("write generated kube config: %v", err)
+	}
+
+	mergedPath := filepath.Join(dir, "merged.yaml")
+	if err := clientcmd.WriteToFile(*api.NewConfig(), mergedPath); err != nil {
+		t.Fatalf("init merged kube config: %v", err)
+	}
+
+	err := mergeIntoSharedKubeConfig(mergedPath, nil, generatedPath, "vcluster_test_demo", vclusterRef{Name: "demo", Namespace: "test"}, true)
+	if err != nil {
+		t.Fatalf("mergeIntoSharedKubeConfig: %v", err)
+	}
+
+	merged, err := clientcmd.LoadFromFile(mergedPath)
+	if err != nil {
+		t.Fatalf("load merged kube config: %v", err)
+	}
+	if _, ok := merged.Contexts["vcluster_test_demo"]; !ok {
+		t.Error("expected the merged kube config to contain the new context")
+	}
+}
+
+func TestMergeIntoSharedKubeConfigCurrent(t *testing.T) {
+	dir := t.TempDir()
+
+	generatedPath := filepath.Join(dir, "generated.yaml")
+	generated := api.NewConfig()
+	generated.Clusters["x"] = &api.Cluster{Server: "https://vcluster.example.com"}
+	generated.AuthInfos["x"] = &api.AuthInfo{Token: "test-token"}
+	if err := clientcmd.WriteToFile(*generated, generatedPath); err != nil {
+		t.Fatalf("write generated kube config: %v", err)
+	}
+
+	currentPath := filepath.Join(dir, "current.yaml")
+	if err := clientcmd.WriteToFile(*api.NewConfig(), currentPath); err != nil {
+		t.Fatalf("init current kube config: %v", err)
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = currentPath
+
+	// mergedKubeConfigPath == "" means --update-current: merge into rules' config instead of a standalone file.
+	err := mergeIntoSharedKubeConfig("", rules, generatedPath, "vcluster_test_demo", vclusterRef{Name: "demo", Namespace: "test"}, true)
+	if err != nil {
+		t.Fatalf("mergeIntoSharedKubeConfig: %v", err)
+	}
+
+	current, err := clientcmd.LoadFromFile(currentPath)
+	if err != nil {
+		t.Fatalf("load current kube config: %v", err)
+	}
+	if _, ok := current.Contexts["vcluster_test_demo"]; !ok {
+		t.Error("expected --update-current to merge the new context into the current kube config")
+	}
+}
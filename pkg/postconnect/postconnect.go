@@ -0,0 +1,211 @@
+// Package postconnect seeds a freshly reachable vcluster with initial objects
+// right after a connect - most importantly image-pull secrets and a small set
+// of bootstrap manifests. It is deliberately independent of how the caller
+// obtained a reachable rest.Config, so `vcluster connect` and `vcluster
+// create --connect` can share the same hooks.
+package postconnect
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// DefaultNamespace is where registry secrets and manifests with no namespace
+// of their own are created when Options.Namespace is empty.
+const DefaultNamespace = "kube-system"
+
+// Options configures what Run seeds into a vcluster.
+type Options struct {
+	// ManifestPaths are local file paths or http(s) URLs to plain Kubernetes
+	// manifests, applied in order.
+	ManifestPaths []string
+
+	// RegistryCreds maps a provider name (e.g. ecr, gcr, acr, dpr) to the path
+	// of a docker config JSON file. Each entry is seeded as an image-pull
+	// secret named "registry-creds-<provider>".
+	RegistryCreds map[string]string
+
+	// Namespace is where registry secrets, and manifests with no namespace of
+	// their own, are created. Defaults to DefaultNamespace.
+	Namespace string
+}
+
+// HasWork returns true if Run would do anything for options.
+func (o Options) HasWork() bool {
+	return len(o.ManifestPaths) > 0 || len(o.RegistryCreds) > 0
+}
+
+// Run applies options against a vcluster that is already reachable via
+// restConfig. It is meant to run right after a connect has produced a usable
+// kube config and the API server behind it is actually answering requests.
+func Run(ctx context.Context, restConfig *rest.Config, options Options) error {
+	namespace := options.Namespace
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	if len(options.RegistryCreds) > 0 {
+		kubeClient, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return errors.Wrap(err, "create kube client")
+		}
+
+		for provider, file := range options.RegistryCreds {
+			err = applyRegistryCreds(ctx, kubeClient, namespace, provider, file)
+			if err != nil {
+				return errors.Wrapf(err, "seed %s registry credentials", provider)
+			}
+		}
+	}
+
+	if len(options.ManifestPaths) > 0 {
+		dynamicClient, mapper, err := dynamicClientAndMapper(restConfig)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range options.ManifestPaths {
+			err = applyManifest(ctx, dynamicClient, mapper, namespace, path)
+			if err != nil {
+				return errors.Wrapf(err, "apply manifest %s", path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyRegistryCreds creates (or updates) a kubernetes.io/dockerconfigjson
+// secret named "registry-creds-<provider>" from the docker config JSON at
+// file, the same naming scheme minikube's `addons configure registry-creds`
+// uses.
+func applyRegistryCreds(ctx context.Context, kubeClient kubernetes.Interface, namespace, provider, file string) error {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return errors.Wrap(err, "read credentials file")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "registry-creds-" + provider,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: raw,
+		},
+	}
+
+	_, err = kubeClient.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if kerrors.IsAlreadyExists(err) {
+		_, err = kubeClient.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+
+	return err
+}
+
+func dynamicClientAndMapper(restConfig *rest.Config) (dynamic.Interface, *restmapper.DeferredDiscoveryRESTMapper, error) {
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create dynamic client")
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create discovery client")
+	}
+
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	return dynamicClient, mapper, nil
+}
+
+// applyManifest decodes every document at path (a local file path or an
+// http(s) URL) and applies it, creating or updating as needed.
+func applyManifest(ctx context.Context, dynamicClient dynamic.Interface, mapper *restmapper.DeferredDiscoveryRESTMapper, defaultNamespace, path string) error {
+	raw, err := readManifest(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(raw), 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(obj)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "decode manifest")
+		} else if len(obj.Object) == 0 {
+			continue
+		}
+
+		err = applyObject(ctx, dynamicClient, mapper, defaultNamespace, obj)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func applyObject(ctx context.Context, dynamicClient dynamic.Interface, mapper *restmapper.DeferredDiscoveryRESTMapper, defaultNamespace string, obj *unstructured.Unstructured) error {
+	gvk := obj.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return errors.Wrap(err, "find rest mapping")
+	}
+
+	var resourceClient dynamic.ResourceInterface
+	if mapping.Scope.Name() == "namespace" {
+		namespace := obj.GetNamespace()
+		if namespace == "" {
+			namespace = defaultNamespace
+			obj.SetNamespace(namespace)
+		}
+		resourceClient = dynamicClient.Resource(mapping.Resource).Namespace(namespace)
+	} else {
+		resourceClient = dynamicClient.Resource(mapping.Resource)
+	}
+
+	_, err = resourceClient.Create(ctx, obj, metav1.CreateOptions{})
+	if kerrors.IsAlreadyExists(err) {
+		existing, getErr := resourceClient.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if getErr != nil {
+			return getErr
+		}
+
+		obj.SetResourceVersion(existing.GetResourceVersion())
+		_, err = resourceClient.Update(ctx, obj, metav1.UpdateOptions{})
+	}
+
+	return err
+}
+
+func readManifest(path string) ([]byte, error) {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		resp, err := http.Get(path)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		return io.ReadAll(resp.Body)
+	}
+
+	return os.ReadFile(path)
+}
@@ -0,0 +1,77 @@
+package postconnect
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func writeCredsFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "creds.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("write credentials file: %v", err)
+	}
+	return path
+}
+
+func TestApplyRegistryCredsCreatesSecret(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	file := writeCredsFile(t, `{"auths":{"ecr":{"auth":"first"}}}`)
+
+	err := applyRegistryCreds(context.Background(), kubeClient, "kube-system", "ecr", file)
+	if err != nil {
+		t.Fatalf("applyRegistryCreds: %v", err)
+	}
+
+	secret, err := kubeClient.CoreV1().Secrets("kube-system").Get(context.Background(), "registry-creds-ecr", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get secret: %v", err)
+	}
+	if secret.Type != corev1.SecretTypeDockerConfigJson {
+		t.Errorf("expected secret type %s, got %s", corev1.SecretTypeDockerConfigJson, secret.Type)
+	}
+	if string(secret.Data[corev1.DockerConfigJsonKey]) != `{"auths":{"ecr":{"auth":"first"}}}` {
+		t.Errorf("unexpected secret data: %s", secret.Data[corev1.DockerConfigJsonKey])
+	}
+}
+
+func TestApplyRegistryCredsUpdatesExistingSecret(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry-creds-ecr", Namespace: "kube-system"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{"ecr":{"auth":"stale"}}}`)},
+	})
+	file := writeCredsFile(t, `{"auths":{"ecr":{"auth":"fresh"}}}`)
+
+	err := applyRegistryCreds(context.Background(), kubeClient, "kube-system", "ecr", file)
+	if err != nil {
+		t.Fatalf("applyRegistryCreds: %v", err)
+	}
+
+	secret, err := kubeClient.CoreV1().Secrets("kube-system").Get(context.Background(), "registry-creds-ecr", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get secret: %v", err)
+	}
+	if string(secret.Data[corev1.DockerConfigJsonKey]) != `{"auths":{"ecr":{"auth":"fresh"}}}` {
+		t.Errorf("expected the existing secret to be updated, got: %s", secret.Data[corev1.DockerConfigJsonKey])
+	}
+}
+
+func TestOptionsHasWork(t *testing.T) {
+	if (Options{}).HasWork() {
+		t.Error("expected empty Options to have no work")
+	}
+	if !(Options{ManifestPaths: []string{"a.yaml"}}).HasWork() {
+		t.Error("expected ManifestPaths alone to count as work")
+	}
+	if !(Options{RegistryCreds: map[string]string{"ecr": "creds.json"}}).HasWork() {
+		t.Error("expected RegistryCreds alone to count as work")
+	}
+}
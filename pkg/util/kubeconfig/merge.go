@@ -0,0 +1,205 @@
+package kubeconfig
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// ManagedByExtensionKey is the well-known kube config extension key vcluster
+// stamps onto every cluster/authInfo/context entry it writes. `vcluster
+// disconnect` and `vcluster connect --prune` use it to recognize entries they
+// are allowed to remove without touching anything that isn't theirs.
+const ManagedByExtensionKey = "vcluster.loft.sh/managed-by"
+
+// managedByExtension is the value stored under ManagedByExtensionKey. It
+// records which vcluster an entry belongs to so a prune can be scoped to a
+// single name instead of wiping every vcluster context at once.
+type managedByExtension struct {
+	VClusterName      string `json:"vclusterName"`
+	VClusterNamespace string `json:"vclusterNamespace"`
+}
+
+func (e *managedByExtension) GetObjectKind() schema.ObjectKind { return schema.EmptyObjectKind }
+
+func (e *managedByExtension) DeepCopyObject() runtime.Object {
+	copied := *e
+	return &copied
+}
+
+// MergeOptions configures how Merge folds a single vcluster's cluster/authInfo
+// into an existing kube config.
+type MergeOptions struct {
+	// ContextName is the name the cluster, authInfo and context entries are
+	// written under, e.g. "vcluster_<namespace>_<name>".
+	ContextName string
+
+	// VClusterName and Namespace are recorded on the managed-by extension so a
+	// later prune can be scoped to a single vcluster.
+	VClusterName string
+	Namespace    string
+
+	// Overwrite allows replacing an existing entry under ContextName even if
+	// it wasn't created by vcluster.
+	Overwrite bool
+
+	// SetCurrent makes ContextName the kube config's current-context.
+	SetCurrent bool
+}
+
+// Merge folds cluster and authInfo into config under options.ContextName,
+// preserving every other entry already present. If an entry already exists
+// under that name and wasn't previously written by vcluster, Merge errors out
+// unless options.Overwrite is set - this is what protects a user's existing
+// kube config from silent data loss.
+func Merge(config *api.Config, cluster *api.Cluster, authInfo *api.AuthInfo, options MergeOptions) error {
+	if existing, ok := config.Clusters[options.ContextName]; ok {
+		if err := checkCollision(existing.Extensions, "cluster", options); err != nil {
+			return err
+		}
+	}
+	if existing, ok := config.AuthInfos[options.ContextName]; ok {
+		if err := checkCollision(existing.Extensions, "user", options); err != nil {
+			return err
+		}
+	}
+	if existing, ok := config.Contexts[options.ContextName]; ok {
+		if err := checkCollision(existing.Extensions, "context", options); err != nil {
+			return err
+		}
+	}
+
+	marker := &managedByExtension{VClusterName: options.VClusterName, VClusterNamespace: options.Namespace}
+
+	cluster = cluster.DeepCopy()
+	cluster.Extensions = withMarker(cluster.Extensions, marker)
+
+	authInfo = authInfo.DeepCopy()
+	authInfo.Extensions = withMarker(authInfo.Extensions, marker)
+
+	context := api.NewContext()
+	context.Cluster = options.ContextName
+	context.AuthInfo = options.ContextName
+	context.Extensions = withMarker(context.Extensions, marker)
+
+	if config.Clusters == nil {
+		config.Clusters = map[string]*api.Cluster{}
+	}
+	if config.AuthInfos == nil {
+		config.AuthInfos = map[string]*api.AuthInfo{}
+	}
+	if config.Contexts == nil {
+		config.Contexts = map[string]*api.Context{}
+	}
+
+	config.Clusters[options.ContextName] = cluster
+	config.AuthInfos[options.ContextName] = authInfo
+	config.Contexts[options.ContextName] = context
+
+	if options.SetCurrent {
+		config.CurrentContext = options.ContextName
+	}
+
+	return nil
+}
+
+func checkCollision(existing map[string]runtime.Object, kind string, options MergeOptions) error {
+	if options.Overwrite {
+		return nil
+	}
+
+	if _, ok := existing[ManagedByExtensionKey]; ok {
+		return nil
+	}
+
+	return fmt.Errorf("kube config already has a %s named %q that wasn't created by vcluster, use --overwrite to replace it", kind, options.ContextName)
+}
+
+func withMarker(extensions map[string]runtime.Object, marker *managedByExtension) map[string]runtime.Object {
+	if extensions == nil {
+		extensions = map[string]runtime.Object{}
+	}
+	extensions[ManagedByExtensionKey] = marker
+	return extensions
+}
+
+// Prune removes every cluster/authInfo/context entry that vcluster previously
+// wrote via Merge for vclusterName, or for any vcluster when vclusterName is
+// empty. It returns the names of the removed contexts.
+func Prune(config *api.Config, vclusterName string) []string {
+	var removed []string
+
+	for name, ctx := range config.Contexts {
+		marker, ok := extensionMarker(ctx.Extensions)
+		if !ok || (vclusterName != "" && marker.VClusterName != vclusterName) {
+			continue
+		}
+
+		delete(config.Contexts, name)
+		delete(config.Clusters, ctx.Cluster)
+		delete(config.AuthInfos, ctx.AuthInfo)
+		if config.CurrentContext == name {
+			config.CurrentContext = ""
+		}
+
+		removed = append(removed, name)
+	}
+
+	return removed
+}
+
+// extensionMarker recovers the managed-by marker Merge stamped onto an entry.
+// The common case is a *managedByExtension still sitting in memory right
+// after Merge wrote it, but a kube config that was written to disk and loaded
+// back - which is how every caller other than Merge's own process actually
+// sees it - decodes unrecognized extensions into a generic *runtime.Unknown
+// instead, so its raw JSON has to be unmarshaled by hand.
+func extensionMarker(extensions map[string]runtime.Object) (*managedByExtension, bool) {
+	raw, ok := extensions[ManagedByExtensionKey]
+	if !ok {
+		return nil, false
+	}
+
+	if marker, ok := raw.(*managedByExtension); ok {
+		return marker, true
+	}
+
+	unknown, ok := raw.(*runtime.Unknown)
+	if !ok {
+		return nil, false
+	}
+
+	marker := &managedByExtension{}
+	if err := json.Unmarshal(unknown.Raw, marker); err != nil {
+		return nil, false
+	}
+
+	return marker, true
+}
+
+// WriteMergedKubeConfig loads the kube config at kubeConfigPath (the default
+// loading rules when empty), merges cluster/authInfo into it and writes it
+// back out.
+func WriteMergedKubeConfig(kubeConfigPath string, cluster *api.Cluster, authInfo *api.AuthInfo, options MergeOptions) error {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeConfigPath != "" {
+		rules.ExplicitPath = kubeConfigPath
+	}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).RawConfig()
+	if err != nil {
+		return errors.Wrap(err, "load kube config")
+	}
+
+	err = Merge(&config, cluster, authInfo, options)
+	if err != nil {
+		return err
+	}
+
+	return clientcmd.ModifyConfig(rules, config, false)
+}
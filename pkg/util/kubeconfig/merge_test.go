@@ -0,0 +1,112 @@
+package kubeconfig
+
+import (
+	"path/filepath"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// TestMergeAndPruneRoundTrip exercises Merge and Prune the way they are
+// actually used in practice: Merge writes a context in one process, and a
+// later process (e.g. `vcluster disconnect`) loads that kube config back from
+// disk before pruning it. An in-memory-only test would miss that the managed-by
+// marker has to survive being written and read back as a kube config extension.
+func TestMergeAndPruneRoundTrip(t *testing.T) {
+	config := api.NewConfig()
+	cluster := &api.Cluster{Server: "https://vcluster.example.com"}
+	authInfo := &api.AuthInfo{Token: "test-token"}
+
+	err := Merge(config, cluster, authInfo, MergeOptions{
+		ContextName:  "vcluster_test_demo",
+		VClusterName: "demo",
+		Namespace:    "test",
+		SetCurrent:   true,
+	})
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "kubeconfig.yaml")
+	if err := clientcmd.WriteToFile(*config, path); err != nil {
+		t.Fatalf("WriteToFile: %v", err)
+	}
+
+	loaded, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+
+	removed := Prune(loaded, "demo")
+	if len(removed) != 1 || removed[0] != "vcluster_test_demo" {
+		t.Fatalf("expected Prune to recognize the context Merge wrote after a round-trip through disk, got %v", removed)
+	}
+
+	if _, ok := loaded.Contexts["vcluster_test_demo"]; ok {
+		t.Error("expected the vcluster context to be removed")
+	}
+	if _, ok := loaded.Clusters["vcluster_test_demo"]; ok {
+		t.Error("expected the vcluster cluster entry to be removed")
+	}
+	if _, ok := loaded.AuthInfos["vcluster_test_demo"]; ok {
+		t.Error("expected the vcluster auth info entry to be removed")
+	}
+}
+
+// TestMergePruneScopedToVClusterName checks that pruning one vcluster leaves a
+// differently-named one untouched, including after a round-trip through disk.
+func TestMergePruneScopedToVClusterName(t *testing.T) {
+	config := api.NewConfig()
+
+	err := Merge(config, &api.Cluster{Server: "https://a.example.com"}, &api.AuthInfo{}, MergeOptions{
+		ContextName:  "vcluster_test_a",
+		VClusterName: "a",
+		Namespace:    "test",
+	})
+	if err != nil {
+		t.Fatalf("Merge a: %v", err)
+	}
+
+	err = Merge(config, &api.Cluster{Server: "https://b.example.com"}, &api.AuthInfo{}, MergeOptions{
+		ContextName:  "vcluster_test_b",
+		VClusterName: "b",
+		Namespace:    "test",
+	})
+	if err != nil {
+		t.Fatalf("Merge b: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "kubeconfig.yaml")
+	if err := clientcmd.WriteToFile(*config, path); err != nil {
+		t.Fatalf("WriteToFile: %v", err)
+	}
+
+	loaded, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+
+	removed := Prune(loaded, "a")
+	if len(removed) != 1 || removed[0] != "vcluster_test_a" {
+		t.Fatalf("expected only vcluster_test_a to be removed, got %v", removed)
+	}
+	if _, ok := loaded.Contexts["vcluster_test_b"]; !ok {
+		t.Error("expected vcluster_test_b to be left untouched")
+	}
+}
+
+func TestMergeCollision(t *testing.T) {
+	config := api.NewConfig()
+	config.Clusters["vcluster_test_demo"] = &api.Cluster{Server: "https://existing.example.com"}
+
+	err := Merge(config, &api.Cluster{}, &api.AuthInfo{}, MergeOptions{ContextName: "vcluster_test_demo"})
+	if err == nil {
+		t.Fatal("expected Merge to refuse overwriting a non-vcluster entry without Overwrite")
+	}
+
+	err = Merge(config, &api.Cluster{}, &api.AuthInfo{}, MergeOptions{ContextName: "vcluster_test_demo", Overwrite: true})
+	if err != nil {
+		t.Fatalf("expected Merge to succeed with Overwrite set: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Package portforward starts and maintains a local port-forward to a pod,
+// restarting it if the underlying tunnel drops (e.g. because the pod
+// restarted) until the caller closes stopCh.
+package portforward
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/loft-sh/vcluster/cmd/vclusterctl/log"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// retryDelay is how long StartPortForwardingWithRestart waits before
+// retrying after a forward that was already up drops.
+const retryDelay = time.Second
+
+// StartPortForwardingWithRestart forwards localPort on address (defaulting
+// to localhost) to remotePort on podName, restarting the forward whenever it
+// drops. It blocks until stopCh is closed, at which point it tears the
+// forward down and returns nil. It only returns an error if the very first
+// attempt to set up the forward fails.
+func StartPortForwardingWithRestart(restConfig *rest.Config, address, podName, namespace, localPort, remotePort string, stopCh chan struct{}, logger log.Logger) error {
+	if address == "" {
+		address = "localhost"
+	}
+
+	first := true
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		default:
+		}
+
+		forwardStopCh := make(chan struct{})
+		readyCh := make(chan struct{})
+		errCh := make(chan error, 1)
+
+		go func() {
+			errCh <- forward(restConfig, address, podName, namespace, localPort, remotePort, forwardStopCh, readyCh)
+		}()
+
+		select {
+		case <-readyCh:
+			first = false
+		case err := <-errCh:
+			if first {
+				return errors.Wrap(err, "start port forwarding")
+			}
+			logger.Infof("Port forwarding to %s interrupted (%v), retrying...", podName, err)
+			time.Sleep(retryDelay)
+			continue
+		case <-stopCh:
+			close(forwardStopCh)
+			return nil
+		}
+
+		select {
+		case <-stopCh:
+			close(forwardStopCh)
+			return nil
+		case err := <-errCh:
+			logger.Infof("Port forwarding to %s interrupted (%v), retrying...", podName, err)
+			time.Sleep(retryDelay)
+		}
+	}
+}
+
+// forward sets up a single port-forward attempt and blocks until it fails or
+// stopCh is closed.
+func forward(restConfig *rest.Config, address, podName, namespace, localPort, remotePort string, stopCh, readyCh chan struct{}) error {
+	transport, upgrader, err := spdy.RoundTripperFor(restConfig)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/api/v1/namespaces/%s/pods/%s/portforward", restConfig.Host, namespace, podName)
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, req.URL)
+
+	fw, err := portforward.NewOnAddresses(dialer, []string{address}, []string{fmt.Sprintf("%s:%s", localPort, remotePort)}, stopCh, readyCh, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return fw.ForwardPorts()
+}